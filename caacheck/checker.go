@@ -0,0 +1,484 @@
+// Package caacheck implements the RFC 8659 CAA tree-climbing algorithm as
+// a library, so it can be embedded in ACME implementations and CI
+// pipelines rather than only driven from the caatest CLI.
+package caacheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrorKind classifies the reason a Check did not result in an authorized
+// issuance, when that reason is a well-defined CAA-checking outcome rather
+// than a configuration or transport failure.
+type ErrorKind string
+
+const (
+	ErrKindNone            ErrorKind = ""
+	ErrKindAliasLoop       ErrorKind = "alias_loop"
+	ErrKindCriticalUnknown ErrorKind = "critical_unknown"
+	ErrKindNoMatch         ErrorKind = "no_match"
+	ErrKindServfail        ErrorKind = "servfail"
+	ErrKindDNSSECBogus     ErrorKind = "dnssec_bogus"
+	ErrKindDNSSECInsecure  ErrorKind = "dnssec_insecure"
+	ErrKindDisagreement    ErrorKind = "resolver_disagreement"
+)
+
+// Records is the CAA RRset for a single name, bucketed by tag.
+type Records struct {
+	Issue     []*dns.CAA `json:"issue,omitempty"`
+	IssueWild []*dns.CAA `json:"issueWild,omitempty"`
+	Iodef     []*dns.CAA `json:"iodef,omitempty"`
+	Unknown   []*dns.CAA `json:"unknown,omitempty"`
+}
+
+func filter(returned []dns.RR) *Records {
+	r := &Records{}
+	for _, rr := range returned {
+		if rr.Header().Rrtype != dns.TypeCAA {
+			continue
+		}
+		caa, ok := rr.(*dns.CAA)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(caa.Tag) {
+		case "issue":
+			r.Issue = append(r.Issue, caa)
+		case "issuewild":
+			r.IssueWild = append(r.IssueWild, caa)
+		case "iodef":
+			r.Iodef = append(r.Iodef, caa)
+		default:
+			r.Unknown = append(r.Unknown, caa)
+		}
+	}
+	return r
+}
+
+// ContainsCriticalUnknown reports whether the set has an unknown-tag
+// record with the critical flag (RFC 8659 §3) set.
+func (r *Records) ContainsCriticalUnknown() bool {
+	for _, rr := range r.Unknown {
+		if (rr.Flag & 128) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Useful reports whether the set has any issue or issuewild records.
+func (r *Records) Useful() bool {
+	return len(r.Issue) > 0 || len(r.IssueWild) > 0
+}
+
+// LabelResult is the CAA lookup outcome for a single name visited while
+// climbing the tree.
+type LabelResult struct {
+	Name         string          `json:"name"`
+	Empty        bool            `json:"empty,omitempty"`
+	Alias        string          `json:"alias,omitempty"`
+	Records      *Records        `json:"records,omitempty"`
+	DNSSECStatus string          `json:"dnssecStatus,omitempty"`
+	Skipped      []SkippedRecord `json:"skipped,omitempty"`
+}
+
+// SkippedRecord is an issue/issuewild record this label's CAA set held that
+// was not considered during matching, because its value couldn't be parsed
+// or carried a parameter this tool doesn't know how to enforce (RFC 8657
+// §3). Per that section, such a record is always treated as non-matching
+// rather than silently ignored, so callers running verbose can see why it
+// didn't count.
+type SkippedRecord struct {
+	Record *dns.CAA `json:"record"`
+	Reason string   `json:"reason"`
+}
+
+// Result is the structured outcome of a Checker.Check call.
+type Result struct {
+	Domain        string        `json:"domain"`
+	Labels        []LabelResult `json:"labels"`
+	AliasChain    []string      `json:"aliasChain,omitempty"`
+	MatchedLabel  string        `json:"matchedLabel,omitempty"`
+	MatchedRecord *dns.CAA      `json:"matchedRecord,omitempty"`
+	Authorized    bool          `json:"authorized"`
+	ErrorKind     ErrorKind     `json:"errorKind,omitempty"`
+
+	// Disagreement holds each resolver's canonicalized CAA RRset, keyed by
+	// resolver address, when Compare is set and they didn't all agree.
+	Disagreement map[string][]string `json:"disagreement,omitempty"`
+}
+
+func (s dnssecStatus) String() string {
+	switch s {
+	case dnssecSecure:
+		return "secure"
+	case dnssecBogus:
+		return "bogus"
+	default:
+		return "insecure"
+	}
+}
+
+// Checker holds the configuration for a CAA check and performs it via
+// Check. The zero value is not usable; construct via NewChecker.
+type Checker struct {
+	// Resolvers is the list of DNS servers to query. For Transport "udp",
+	// "tcp", or "tls" each entry is a host[:port] pair; for "https" and
+	// "quic" each is a full resolver URL (e.g.
+	// "https://1.1.1.1/dns-query"). If empty, every server listed in
+	// /etc/resolv.conf is used (udp/tcp/tls only).
+	Resolvers []string
+	// Transport is one of "udp", "tcp", "tls", "https", or "quic".
+	Transport string
+	// Timeout bounds each individual DNS query.
+	Timeout time.Duration
+
+	// Compare requires every resolver in Resolvers to return a
+	// byte-identical (after canonical sorting) CAA RRset at each label;
+	// disagreement is reported via ErrKindDisagreement and Disagreement
+	// rather than silently picking one answer.
+	Compare bool
+
+	// Issuer is the CA domain name to check for. If empty, Check will
+	// never set Authorized and will instead return the full CAA set
+	// found at every label.
+	Issuer string
+	// AccountURI and ValidationMethods enforce the RFC 8657 issue-tag
+	// parameters of the same name, when a candidate record carries them.
+	AccountURI        string
+	ValidationMethods []string
+
+	// DNSSEC is one of "" (disabled), "trust-ad", or "validate".
+	DNSSEC string
+	// RequireDNSSEC causes Check to report ErrKindDNSSECInsecure instead
+	// of an ordinary unauthenticated match when CAA cannot be validated.
+	RequireDNSSEC bool
+}
+
+// NewChecker returns a Checker with the same defaults as the caatest CLI.
+func NewChecker() *Checker {
+	return &Checker{
+		Transport: "udp",
+		Timeout:   5 * time.Second,
+	}
+}
+
+func (c *Checker) resolveUpstreams() ([]string, error) {
+	if len(c.Resolvers) > 0 {
+		return c.Resolvers, nil
+	}
+	if c.Transport == "https" || c.Transport == "quic" {
+		return nil, fmt.Errorf("Resolvers must be set to resolver URLs when Transport is %q", c.Transport)
+	}
+	cc, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, fmt.Errorf("reading nameservers from /etc/resolv.conf: %w", err)
+	}
+	if len(cc.Servers) == 0 {
+		return nil, errors.New("/etc/resolv.conf contains no nameservers")
+	}
+	upstreams := make([]string, len(cc.Servers))
+	for i, s := range cc.Servers {
+		upstreams[i] = upstreamAddr(c.Transport, s, cc.Port)
+	}
+	return upstreams, nil
+}
+
+// upstreamAddr builds the resolver address resolveUpstreams passes to
+// newExchanger for a server and port read from resolv.conf. For "tls" the
+// port is left off so newExchanger's DoT default of 853 applies, instead
+// of baking in resolv.conf's plain-DNS port 53.
+func upstreamAddr(transport, server, port string) string {
+	if transport == "tls" {
+		return server
+	}
+	return fmt.Sprintf("%s:%s", server, port)
+}
+
+// Check performs the RFC 8659 CAA tree-climb for domain and returns a
+// structured Result. A non-nil error indicates a configuration or
+// transport-level failure (bad resolver, bad flags, context cancellation);
+// domain-semantic outcomes (no matching issuer, critical-unknown record,
+// SERVFAIL, alias loop, DNSSEC failure) are instead reported via
+// Result.ErrorKind with a nil error, so callers always get a Result to
+// serialize even when the domain is not authorized.
+func (c *Checker) Check(ctx context.Context, domain string) (*Result, error) {
+	switch c.DNSSEC {
+	case "", "trust-ad", "validate":
+	default:
+		return nil, fmt.Errorf("unknown DNSSEC mode %q (must be \"trust-ad\" or \"validate\")", c.DNSSEC)
+	}
+
+	upstreams, err := c.resolveUpstreams()
+	if err != nil {
+		return nil, err
+	}
+	exchangers, err := newExchangers(c.Transport, upstreams, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	validators := make(map[string]*dnssecValidator)
+	validatorFor := func(resolver string) *dnssecValidator {
+		if v, ok := validators[resolver]; ok {
+			return v
+		}
+		for _, ne := range exchangers {
+			if ne.Resolver == resolver {
+				v := newDNSSECValidator(ne.Exchanger)
+				validators[resolver] = v
+				return v
+			}
+		}
+		return nil
+	}
+
+	result := &Result{Domain: domain}
+
+	// RFC 8659 §3: at each name, query CAA; if it's a CNAME/DNAME, restart
+	// the whole algorithm at the alias target's apex rather than climbing
+	// the *original* domain's ancestors; otherwise, only climb to the
+	// parent when the name has no CAA records of its own. visited guards
+	// against alias loops (including a name aliasing back to an ancestor
+	// it would otherwise have climbed to).
+	visited := make(map[string]bool)
+	current := dns.Fqdn(domain)
+	for {
+		if visited[current] {
+			result.ErrorKind = ErrKindAliasLoop
+			return result, nil
+		}
+		visited[current] = true
+
+		chosen, all := fanOutQuery(ctx, current, dns.TypeCAA, exchangers, c.DNSSEC != "")
+		if c.Compare {
+			if agree, diff := diffResolvers(all); !agree || anyFailed(all) {
+				result.ErrorKind = ErrKindDisagreement
+				result.Disagreement = diff
+				return result, nil
+			}
+		}
+		if chosen == nil {
+			result.ErrorKind = classifyFailure(all)
+			return result, nil
+		}
+		resp, authenticated := chosen.Answer, chosen.Authenticated
+
+		lr := LabelResult{Name: current}
+
+		if alias, ok := aliasTarget(resp); ok {
+			lr.Alias = alias
+			result.Labels = append(result.Labels, lr)
+			result.AliasChain = append(result.AliasChain, alias)
+			current = dns.Fqdn(alias)
+			continue
+		}
+
+		if len(resp) == 0 {
+			lr.Empty = true
+			result.Labels = append(result.Labels, lr)
+			if parent, ok := parentOf(current); ok {
+				current = parent
+				continue
+			}
+			return result, nil
+		}
+
+		set := filter(resp)
+		lr.Records = set
+		lr.Skipped = skippedIssueRecords(set)
+
+		if c.DNSSEC != "" && set.Useful() {
+			var status dnssecStatus
+			var derr error
+			switch c.DNSSEC {
+			case "trust-ad":
+				if authenticated {
+					status = dnssecSecure
+				} else {
+					status = dnssecInsecure
+				}
+			case "validate":
+				status, derr = validatorFor(chosen.Resolver).validateCAA(ctx, current, resp)
+			}
+			lr.DNSSECStatus = status.String()
+			if status == dnssecBogus {
+				result.Labels = append(result.Labels, lr)
+				result.ErrorKind = ErrKindDNSSECBogus
+				return result, derr
+			}
+			if status == dnssecInsecure && c.RequireDNSSEC {
+				result.Labels = append(result.Labels, lr)
+				result.ErrorKind = ErrKindDNSSECInsecure
+				return result, nil
+			}
+		}
+
+		result.Labels = append(result.Labels, lr)
+
+		if set.ContainsCriticalUnknown() {
+			result.ErrorKind = ErrKindCriticalUnknown
+			return result, nil
+		}
+		if !set.Useful() {
+			if parent, ok := parentOf(current); ok {
+				current = parent
+				continue
+			}
+			return result, nil
+		}
+		if c.Issuer == "" {
+			// Full-chain display mode keeps climbing even past a useful
+			// set, since the point is to show every label's records.
+			if parent, ok := parentOf(current); ok {
+				current = parent
+				continue
+			}
+			return result, nil
+		}
+		if strings.HasPrefix(current, "*.") {
+			if len(set.IssueWild) == 0 {
+				if parent, ok := parentOf(current); ok {
+					current = parent
+					continue
+				}
+				return result, nil
+			}
+			for _, rr := range set.IssueWild {
+				parsed, err := parseIssueTagValue(rr.Value)
+				if err != nil {
+					continue
+				}
+				ok, unknownParam := parsed.matches(c.Issuer, c.AccountURI, c.ValidationMethods)
+				if unknownParam != "" {
+					continue
+				}
+				if ok {
+					result.Authorized = true
+					result.MatchedLabel = current
+					result.MatchedRecord = rr
+					return result, nil
+				}
+			}
+			result.ErrorKind = ErrKindNoMatch
+			return result, nil
+		}
+
+		if len(set.Issue) == 0 {
+			if parent, ok := parentOf(current); ok {
+				current = parent
+				continue
+			}
+			return result, nil
+		}
+		for _, rr := range set.Issue {
+			parsed, err := parseIssueTagValue(rr.Value)
+			if err != nil {
+				continue
+			}
+			ok, unknownParam := parsed.matches(c.Issuer, c.AccountURI, c.ValidationMethods)
+			if unknownParam != "" {
+				continue
+			}
+			if ok {
+				result.Authorized = true
+				result.MatchedLabel = current
+				result.MatchedRecord = rr
+				return result, nil
+			}
+		}
+		result.ErrorKind = ErrKindNoMatch
+		return result, nil
+	}
+}
+
+// skippedIssueRecords reports which of set's issue/issuewild records will
+// be treated as non-matching because they're malformed or carry a
+// parameter this tool doesn't understand how to enforce.
+func skippedIssueRecords(set *Records) []SkippedRecord {
+	var skipped []SkippedRecord
+	for _, rr := range append(append([]*dns.CAA{}, set.Issue...), set.IssueWild...) {
+		parsed, err := parseIssueTagValue(rr.Value)
+		if err != nil {
+			skipped = append(skipped, SkippedRecord{Record: rr, Reason: err.Error()})
+			continue
+		}
+		if _, unknownParam := parsed.matches("", "", nil); unknownParam != "" {
+			skipped = append(skipped, SkippedRecord{Record: rr, Reason: fmt.Sprintf("unrecognized parameter %q", unknownParam)})
+		}
+	}
+	return skipped
+}
+
+// aliasTarget reports whether answer is a single CNAME/DNAME record (i.e.
+// the queried name is itself an alias rather than holding CAA records
+// directly), and if so, its target.
+func aliasTarget(answer []dns.RR) (target string, ok bool) {
+	if len(answer) != 1 {
+		return "", false
+	}
+	switch t := answer[0].(type) {
+	case *dns.CNAME:
+		return t.Target, true
+	case *dns.DNAME:
+		return t.Target, true
+	default:
+		return "", false
+	}
+}
+
+// parentOf returns the immediate parent of a fully-qualified name, and
+// false if name is already a single label (so there is no parent to climb
+// to).
+func parentOf(name string) (string, bool) {
+	labels := dns.SplitDomainName(name)
+	if len(labels) <= 1 {
+		return "", false
+	}
+	return dns.Fqdn(strings.Join(labels[1:], ".")), true
+}
+
+// anyFailed reports whether any resolver in results returned an error,
+// which in -compare mode is itself treated as a disagreement: we can't
+// vouch for consensus if not every resolver could be asked.
+func anyFailed(results []*resolverResult) bool {
+	for _, r := range results {
+		if r.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyFailure picks an ErrorKind to report when every resolver in
+// results failed.
+func classifyFailure(results []*resolverResult) ErrorKind {
+	return ErrKindServfail
+}
+
+// query issues a single DNS query for name/rrType via exchanger. Unlike
+// earlier versions of this function, it does not follow CNAME/DNAME aliases
+// itself: the CAA tree-climb in Check needs to see an alias answer directly
+// so it can restart the climb at the alias target's own apex, per RFC 8659
+// §3, rather than keep climbing ancestors of the original name.
+func query(ctx context.Context, name string, rrType uint16, exchanger Exchanger, dnssec bool) ([]dns.RR, bool, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), rrType)
+	m.RecursionDesired = true
+	if dnssec {
+		m.SetEdns0(4096, true)
+	}
+	resp, err := exchanger.Exchange(ctx, m)
+	if err != nil {
+		return nil, false, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, false, fmt.Errorf("non-zero RCODE in response (%s)", dns.RcodeToString[resp.Rcode])
+	}
+	return resp.Answer, resp.AuthenticatedData, nil
+}
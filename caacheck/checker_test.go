@@ -0,0 +1,263 @@
+package caacheck
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startFakeResolver spins up a local, in-process DNS server over UDP so
+// Checker.Check can be exercised through its real "udp" transport without
+// any network access, and returns its "127.0.0.1:port" address.
+func startFakeResolver(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening for fake resolver: %v", err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: handler}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func caaAnswer(owner, tag, value string) *dns.CAA {
+	return &dns.CAA{
+		Hdr:   dns.RR_Header{Name: dns.Fqdn(owner), Rrtype: dns.TypeCAA, Class: dns.ClassINET, Ttl: 3600},
+		Tag:   tag,
+		Value: value,
+	}
+}
+
+func testChecker(t *testing.T, resolvers []string) *Checker {
+	t.Helper()
+	c := NewChecker()
+	c.Resolvers = resolvers
+	c.Timeout = 2 * time.Second
+	return c
+}
+
+// TestCheck_ClimbsToParentWhenEmpty exercises the RFC 8659 tree-climb: a
+// name with no CAA records of its own defers to its parent's set.
+func TestCheck_ClimbsToParentWhenEmpty(t *testing.T) {
+	addr := startFakeResolver(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "example.com." {
+			m.Answer = []dns.RR{caaAnswer("example.com.", "issue", "letsencrypt.org")}
+		}
+		w.WriteMsg(m)
+	})
+
+	c := testChecker(t, []string{addr})
+	c.Issuer = "letsencrypt.org"
+	result, err := c.Check(context.Background(), "www.example.com.")
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if !result.Authorized {
+		t.Fatalf("Authorized = false, want true (result: %+v)", result)
+	}
+	if result.MatchedLabel != "example.com." {
+		t.Errorf("MatchedLabel = %q, want %q", result.MatchedLabel, "example.com.")
+	}
+	wantLabels := []string{"www.example.com.", "example.com."}
+	if len(result.Labels) != len(wantLabels) {
+		t.Fatalf("visited %d labels, want %d: %+v", len(result.Labels), len(wantLabels), result.Labels)
+	}
+	for i, name := range wantLabels {
+		if result.Labels[i].Name != name {
+			t.Errorf("Labels[%d].Name = %q, want %q", i, result.Labels[i].Name, name)
+		}
+	}
+}
+
+// TestCheck_AliasRestartsAtTarget checks that a CNAME/DNAME restarts the
+// whole climb at the alias target, rather than just climbing the original
+// name's ancestors.
+func TestCheck_AliasRestartsAtTarget(t *testing.T) {
+	addr := startFakeResolver(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		switch r.Question[0].Name {
+		case "www.example.com.":
+			m.Answer = []dns.RR{&dns.CNAME{
+				Hdr:    dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 3600},
+				Target: "edge.cdn.net.",
+			}}
+		case "edge.cdn.net.":
+			m.Answer = []dns.RR{caaAnswer("edge.cdn.net.", "issue", "letsencrypt.org")}
+		}
+		w.WriteMsg(m)
+	})
+
+	c := testChecker(t, []string{addr})
+	c.Issuer = "letsencrypt.org"
+	result, err := c.Check(context.Background(), "www.example.com.")
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if !result.Authorized {
+		t.Fatalf("Authorized = false, want true (result: %+v)", result)
+	}
+	if len(result.AliasChain) != 1 || result.AliasChain[0] != "edge.cdn.net." {
+		t.Errorf("AliasChain = %v, want [edge.cdn.net.]", result.AliasChain)
+	}
+	if result.MatchedLabel != "edge.cdn.net." {
+		t.Errorf("MatchedLabel = %q, want %q", result.MatchedLabel, "edge.cdn.net.")
+	}
+}
+
+// TestCheck_AliasLoopIsDetected checks that a name aliasing back to an
+// ancestor it would otherwise climb to is reported, rather than looping
+// forever.
+func TestCheck_AliasLoopIsDetected(t *testing.T) {
+	addr := startFakeResolver(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{&dns.CNAME{
+			Hdr:    dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 3600},
+			Target: "a.example.com.",
+		}}
+		w.WriteMsg(m)
+	})
+
+	c := testChecker(t, []string{addr})
+	c.Issuer = "letsencrypt.org"
+	result, err := c.Check(context.Background(), "a.example.com.")
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if result.ErrorKind != ErrKindAliasLoop {
+		t.Errorf("ErrorKind = %q, want %q", result.ErrorKind, ErrKindAliasLoop)
+	}
+}
+
+// TestCheck_CompareDetectsDisagreement exercises the -compare fan-out path:
+// two resolvers returning different CAA sets for the same name must be
+// reported as a disagreement rather than one answer silently winning.
+func TestCheck_CompareDetectsDisagreement(t *testing.T) {
+	addrA := startFakeResolver(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{caaAnswer(r.Question[0].Name, "issue", "letsencrypt.org")}
+		w.WriteMsg(m)
+	})
+	addrB := startFakeResolver(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{caaAnswer(r.Question[0].Name, "issue", "sectigo.com")}
+		w.WriteMsg(m)
+	})
+
+	c := testChecker(t, []string{addrA, addrB})
+	c.Compare = true
+	c.Issuer = "letsencrypt.org"
+	result, err := c.Check(context.Background(), "example.com.")
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if result.ErrorKind != ErrKindDisagreement {
+		t.Fatalf("ErrorKind = %q, want %q", result.ErrorKind, ErrKindDisagreement)
+	}
+	if len(result.Disagreement) != 2 {
+		t.Errorf("Disagreement has %d entries, want 2: %+v", len(result.Disagreement), result.Disagreement)
+	}
+}
+
+// TestCheck_CompareAcceptsAgreement checks the converse: identical CAA sets
+// across every resolver shouldn't be flagged.
+func TestCheck_CompareAcceptsAgreement(t *testing.T) {
+	handler := func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{caaAnswer(r.Question[0].Name, "issue", "letsencrypt.org")}
+		w.WriteMsg(m)
+	}
+	addrA := startFakeResolver(t, handler)
+	addrB := startFakeResolver(t, handler)
+
+	c := testChecker(t, []string{addrA, addrB})
+	c.Compare = true
+	c.Issuer = "letsencrypt.org"
+	result, err := c.Check(context.Background(), "example.com.")
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if result.ErrorKind != ErrKindNone {
+		t.Errorf("ErrorKind = %q, want none", result.ErrorKind)
+	}
+	if !result.Authorized {
+		t.Errorf("Authorized = false, want true")
+	}
+}
+
+// TestCheck_MatchesWildcardIssueWild checks that a real wildcard domain
+// (e.g. "*.example.com") is matched against issuewild records, including
+// the RFC 8657 accounturi/validationmethods enforcement.
+func TestCheck_MatchesWildcardIssueWild(t *testing.T) {
+	addr := startFakeResolver(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{caaAnswer(r.Question[0].Name, "issuewild", "letsencrypt.org; accounturi=https://acme.example/acct/1")}
+		w.WriteMsg(m)
+	})
+
+	c := testChecker(t, []string{addr})
+	c.Issuer = "letsencrypt.org"
+	c.AccountURI = "https://acme.example/acct/1"
+	result, err := c.Check(context.Background(), "*.example.com")
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if !result.Authorized {
+		t.Fatalf("Authorized = false, want true (result: %+v)", result)
+	}
+	if result.MatchedLabel != "*.example.com." {
+		t.Errorf("MatchedLabel = %q, want %q", result.MatchedLabel, "*.example.com.")
+	}
+
+	c.AccountURI = "https://acme.example/acct/2"
+	result, err = c.Check(context.Background(), "*.example.com")
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if result.Authorized {
+		t.Errorf("Authorized = true, want false (accounturi mismatch should not match)")
+	}
+	if result.ErrorKind != ErrKindNoMatch {
+		t.Errorf("ErrorKind = %q, want %q", result.ErrorKind, ErrKindNoMatch)
+	}
+}
+
+// TestUpstreamAddr_TLSLeavesPortUnset is a regression test: resolv.conf's
+// plain-DNS port must not get baked into a "tls" transport's resolver
+// address, or newExchanger's DoT default of 853 never has a chance to
+// apply and -transport tls silently dials port 53 instead.
+func TestUpstreamAddr_TLSLeavesPortUnset(t *testing.T) {
+	if got := upstreamAddr("tls", "1.1.1.1", "53"); got != "1.1.1.1" {
+		t.Errorf("upstreamAddr(tls, ...) = %q, want %q", got, "1.1.1.1")
+	}
+	if got := upstreamAddr("udp", "1.1.1.1", "53"); got != "1.1.1.1:53" {
+		t.Errorf("upstreamAddr(udp, ...) = %q, want %q", got, "1.1.1.1:53")
+	}
+}
+
+// TestSkippedIssueRecords checks that malformed and unknown-parameter
+// records are reported as skipped rather than silently dropped.
+func TestSkippedIssueRecords(t *testing.T) {
+	set := &Records{
+		Issue: []*dns.CAA{
+			caaAnswer("example.com.", "issue", "letsencrypt.org; unknownparam=1"),
+			caaAnswer("example.com.", "issue", "letsencrypt.org; ="),
+			caaAnswer("example.com.", "issue", "letsencrypt.org"),
+		},
+	}
+	skipped := skippedIssueRecords(set)
+	if len(skipped) != 2 {
+		t.Fatalf("got %d skipped records, want 2: %+v", len(skipped), skipped)
+	}
+}
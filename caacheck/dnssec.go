@@ -0,0 +1,253 @@
+package caacheck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnssecStatus describes the outcome of authenticating a CAA RRset.
+type dnssecStatus int
+
+const (
+	dnssecInsecure dnssecStatus = iota // provably unsigned
+	dnssecSecure                       // validated all the way to the root
+	dnssecBogus                        // signed, but validation failed
+)
+
+// rootTrustAnchors are the IANA root zone KSKs, used to anchor local
+// DNSSEC validation chains. See https://data.iana.org/root-anchors/.
+var rootTrustAnchors = []*dns.DS{
+	{
+		Hdr:        dns.RR_Header{Name: ".", Rrtype: dns.TypeDS, Class: dns.ClassINET},
+		KeyTag:     20326,
+		Algorithm:  dns.RSASHA256,
+		DigestType: dns.SHA256,
+		Digest:     "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8",
+	},
+	{
+		Hdr:        dns.RR_Header{Name: ".", Rrtype: dns.TypeDS, Class: dns.ClassINET},
+		KeyTag:     38696,
+		Algorithm:  dns.RSASHA256,
+		DigestType: dns.SHA256,
+		Digest:     "683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+	},
+}
+
+// dnssecValidator performs local DNSSEC validation of CAA RRsets, caching
+// DNSKEY and DS lookups per zone so a single tree-climb only fetches each
+// zone's keys once.
+type dnssecValidator struct {
+	exchanger  Exchanger
+	dnskeyzone map[string][]*dns.DNSKEY
+	dszone     map[string][]*dns.DS
+}
+
+func newDNSSECValidator(exchanger Exchanger) *dnssecValidator {
+	return &dnssecValidator{
+		exchanger:  exchanger,
+		dnskeyzone: make(map[string][]*dns.DNSKEY),
+		dszone:     make(map[string][]*dns.DS),
+	}
+}
+
+func (v *dnssecValidator) dnskeysForZone(ctx context.Context, zone string) ([]*dns.DNSKEY, error) {
+	zone = dns.Fqdn(zone)
+	if keys, ok := v.dnskeyzone[zone]; ok {
+		return keys, nil
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeDNSKEY)
+	m.SetEdns0(4096, true)
+	resp, err := v.exchanger.Exchange(ctx, m)
+	if err != nil {
+		return nil, fmt.Errorf("querying DNSKEY for %s: %w", zone, err)
+	}
+	var keys []*dns.DNSKEY
+	for _, rr := range resp.Answer {
+		if k, ok := rr.(*dns.DNSKEY); ok {
+			keys = append(keys, k)
+		}
+	}
+	v.dnskeyzone[zone] = keys
+	return keys, nil
+}
+
+func (v *dnssecValidator) dsForZone(ctx context.Context, zone string) ([]*dns.DS, error) {
+	zone = dns.Fqdn(zone)
+	if zone == "." {
+		return rootTrustAnchors, nil
+	}
+	if ds, ok := v.dszone[zone]; ok {
+		return ds, nil
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeDS)
+	m.SetEdns0(4096, true)
+	resp, err := v.exchanger.Exchange(ctx, m)
+	if err != nil {
+		return nil, fmt.Errorf("querying DS for %s: %w", zone, err)
+	}
+	var ds []*dns.DS
+	for _, rr := range resp.Answer {
+		if d, ok := rr.(*dns.DS); ok {
+			ds = append(ds, d)
+		}
+	}
+	v.dszone[zone] = ds
+	return ds, nil
+}
+
+// validateZoneKeys confirms that zone's DNSKEY RRset is covered by a DS
+// record held by its parent, recursing up to the root trust anchors.
+func (v *dnssecValidator) validateZoneKeys(ctx context.Context, zone string) (dnssecStatus, error) {
+	zone = dns.Fqdn(zone)
+	keys, err := v.dnskeysForZone(ctx, zone)
+	if err != nil {
+		return dnssecBogus, err
+	}
+	if len(keys) == 0 {
+		return dnssecInsecure, nil
+	}
+
+	parent := "."
+	if zone != "." {
+		parent = dns.Fqdn(parentZone(zone))
+	}
+	ds, err := v.dsForZone(ctx, parent)
+	if err != nil {
+		return dnssecBogus, err
+	}
+	if len(ds) == 0 {
+		// No DS at the parent: the zone is provably unsigned, unless the
+		// parent itself turns out to be bogus below.
+		return dnssecInsecure, nil
+	}
+
+	var matched *dns.DNSKEY
+	for _, k := range keys {
+		for _, d := range ds {
+			if k.ToDS(d.DigestType).Digest == d.Digest && k.KeyTag() == d.KeyTag {
+				matched = k
+				break
+			}
+		}
+		if matched != nil {
+			break
+		}
+	}
+	if matched == nil {
+		return dnssecBogus, fmt.Errorf("no DNSKEY for %s matches a DS record at %s", zone, parent)
+	}
+
+	if zone == "." {
+		return dnssecSecure, nil
+	}
+	parentStatus, err := v.validateZoneKeys(ctx, parent)
+	if err != nil || parentStatus != dnssecSecure {
+		return parentStatus, err
+	}
+	return dnssecSecure, nil
+}
+
+// validateCAA checks that the given CAA RRset for name, along with its
+// covering RRSIG, authenticates back to the root. It returns dnssecInsecure
+// if the zone is provably unsigned, dnssecSecure if validation succeeded,
+// and dnssecBogus (with an explanatory error) otherwise.
+func (v *dnssecValidator) validateCAA(ctx context.Context, name string, rrset []dns.RR) (dnssecStatus, error) {
+	name = dns.Fqdn(name)
+	var sig *dns.RRSIG
+	var caas []dns.RR
+	for _, rr := range rrset {
+		if s, ok := rr.(*dns.RRSIG); ok && s.TypeCovered == dns.TypeCAA {
+			sig = s
+			continue
+		}
+		if rr.Header().Rrtype == dns.TypeCAA {
+			caas = append(caas, rr)
+		}
+	}
+	if len(caas) == 0 {
+		return dnssecInsecure, nil
+	}
+	if sig == nil {
+		zone, err := v.enclosingZoneApex(ctx, name)
+		if err == nil {
+			zoneStatus, err := v.validateZoneKeys(ctx, zone)
+			if err == nil && zoneStatus == dnssecInsecure {
+				return dnssecInsecure, nil
+			}
+		}
+		return dnssecBogus, fmt.Errorf("CAA RRset for %s has no covering RRSIG", name)
+	}
+	if !sig.ValidityPeriod(time.Now()) {
+		return dnssecBogus, fmt.Errorf("RRSIG for %s CAA RRset is outside its validity period", name)
+	}
+
+	keys, err := v.dnskeysForZone(ctx, sig.SignerName)
+	if err != nil {
+		return dnssecBogus, err
+	}
+	var verifyErr error
+	verified := false
+	for _, k := range keys {
+		if k.KeyTag() != sig.KeyTag || k.Algorithm != sig.Algorithm {
+			continue
+		}
+		if err := sig.Verify(k, caas); err != nil {
+			verifyErr = err
+			continue
+		}
+		verified = true
+		break
+	}
+	if !verified {
+		if verifyErr == nil {
+			verifyErr = fmt.Errorf("no DNSKEY matching RRSIG key tag %d found in zone %s", sig.KeyTag, sig.SignerName)
+		}
+		return dnssecBogus, fmt.Errorf("validating RRSIG for %s CAA RRset: %w", name, verifyErr)
+	}
+
+	status, err := v.validateZoneKeys(ctx, sig.SignerName)
+	if err != nil {
+		return dnssecBogus, err
+	}
+	if status != dnssecSecure {
+		return status, err
+	}
+	return dnssecSecure, nil
+}
+
+// parentZone returns the immediate parent of zone, e.g. "example.com." ->
+// "com.". zone must be fully-qualified.
+func parentZone(zone string) string {
+	labels := dns.SplitDomainName(zone)
+	if len(labels) <= 1 {
+		return "."
+	}
+	return dns.Fqdn(strings.Join(labels[1:], "."))
+}
+
+// enclosingZoneApex is used when a name has no CAA RRSIG at all, to find
+// the zone apex that actually holds the DNSKEY/DS-rooted chain covering
+// name, so validateZoneKeys can tell a genuinely unsigned zone (INSECURE)
+// apart from a signed zone whose RRSIG was stripped in transit (BOGUS).
+// It climbs from name's immediate parent upward until it finds a zone
+// with its own DNSKEY RRset, or reaches the root.
+func (v *dnssecValidator) enclosingZoneApex(ctx context.Context, name string) (string, error) {
+	zone := dns.Fqdn(name)
+	for zone != "." {
+		zone = dns.Fqdn(parentZone(zone))
+		keys, err := v.dnskeysForZone(ctx, zone)
+		if err != nil {
+			return "", err
+		}
+		if len(keys) > 0 {
+			return zone, nil
+		}
+	}
+	return ".", nil
+}
@@ -0,0 +1,166 @@
+package caacheck
+
+import (
+	"context"
+	"crypto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeExchanger answers DNSKEY/DS/CAA queries out of a static zone map,
+// keyed by qname+qtype, so tests can exercise dnssecValidator without any
+// network access.
+type fakeExchanger struct {
+	answers map[string]*dns.Msg
+}
+
+func fakeKey(qname string, qtype uint16) string {
+	return dns.Fqdn(qname) + "/" + dns.TypeToString[qtype]
+}
+
+func (f *fakeExchanger) Exchange(_ context.Context, m *dns.Msg) (*dns.Msg, error) {
+	q := m.Question[0]
+	resp, ok := f.answers[fakeKey(q.Name, q.Qtype)]
+	if !ok {
+		return &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeSuccess}}, nil
+	}
+	return resp, nil
+}
+
+// newSignedZone generates a DNSKEY for zone and returns it along with the
+// matching DS record (as the parent would publish it).
+func newSignedZone(t *testing.T, zone string) (*dns.DNSKEY, crypto.Signer, *dns.DS) {
+	t.Helper()
+	k := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: dns.Fqdn(zone), Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := k.Generate(256)
+	if err != nil {
+		t.Fatalf("generating key for %s: %v", zone, err)
+	}
+	ds := k.ToDS(dns.SHA256)
+	return k, priv.(crypto.Signer), ds
+}
+
+func msgWithAnswer(rrs ...dns.RR) *dns.Msg {
+	return &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeSuccess}, Answer: rrs}
+}
+
+// TestValidateCAA_StrippedRRSIGOnSignedZoneIsBogus is a regression test: a
+// CAA RRset with no covering RRSIG at a name whose enclosing zone is
+// actually signed must be reported BOGUS (tampered), not INSECURE.
+func TestValidateCAA_StrippedRRSIGOnSignedZoneIsBogus(t *testing.T) {
+	exampleKey, _, exampleDS := newSignedZone(t, "example.com.")
+	comKey, _, _ := newSignedZone(t, "com.")
+
+	f := &fakeExchanger{answers: map[string]*dns.Msg{
+		fakeKey("www.example.com.", dns.TypeDNSKEY): msgWithAnswer(), // leaf has no DNSKEY of its own
+		fakeKey("example.com.", dns.TypeDNSKEY):     msgWithAnswer(exampleKey),
+		fakeKey("com.", dns.TypeDS):                 msgWithAnswer(exampleDS),
+		// "com." publishes its own DNSKEY, but it won't match the real
+		// root trust anchors baked into the validator, so the chain bottoms
+		// out BOGUS rather than validating all the way to the (real) root
+		// — which is exactly what "signed but can't fully verify" looks
+		// like, as opposed to "provably unsigned".
+		fakeKey("com.", dns.TypeDNSKEY): msgWithAnswer(comKey),
+	}}
+	v := newDNSSECValidator(f)
+
+	caa := &dns.CAA{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCAA, Class: dns.ClassINET}, Tag: "issue", Value: "letsencrypt.org"}
+
+	status, err := v.validateCAA(context.Background(), "www.example.com.", []dns.RR{caa})
+	if status != dnssecBogus {
+		t.Errorf("status = %v, want dnssecBogus (err: %v)", status, err)
+	}
+	if err == nil {
+		t.Error("expected a non-nil error explaining the missing RRSIG")
+	}
+}
+
+// TestValidateCAA_StrippedRRSIGOnUnsignedZoneIsInsecure checks the
+// companion case: if no ancestor zone up to the root is actually signed,
+// a missing RRSIG is just what an unsigned domain looks like.
+func TestValidateCAA_StrippedRRSIGOnUnsignedZoneIsInsecure(t *testing.T) {
+	f := &fakeExchanger{answers: map[string]*dns.Msg{
+		// No DNSKEY anywhere in the chain, and no DS at "." for "com.".
+		fakeKey("www.example.com.", dns.TypeDNSKEY): msgWithAnswer(),
+		fakeKey("example.com.", dns.TypeDNSKEY):     msgWithAnswer(),
+		fakeKey("com.", dns.TypeDNSKEY):             msgWithAnswer(),
+	}}
+	v := newDNSSECValidator(f)
+
+	caa := &dns.CAA{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCAA, Class: dns.ClassINET}, Tag: "issue", Value: "letsencrypt.org"}
+
+	status, err := v.validateCAA(context.Background(), "www.example.com.", []dns.RR{caa})
+	if status != dnssecInsecure {
+		t.Errorf("status = %v, err = %v, want dnssecInsecure", status, err)
+	}
+}
+
+// TestValidateCAA_ValidSignatureIsSecure exercises the full signature
+// verification path with a real generated key and signature.
+func TestValidateCAA_ValidSignatureIsSecure(t *testing.T) {
+	exampleKey, examplePriv, exampleDS := newSignedZone(t, "example.com.")
+
+	caa := &dns.CAA{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeCAA, Class: dns.ClassINET, Ttl: 3600}, Tag: "issue", Value: "letsencrypt.org"}
+	sig := &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		TypeCovered: dns.TypeCAA,
+		Algorithm:   dns.ECDSAP256SHA256,
+		Labels:      2,
+		OrigTtl:     3600,
+		Expiration:  uint32(time.Now().Add(24 * time.Hour).Unix()),
+		Inception:   uint32(time.Now().Add(-24 * time.Hour).Unix()),
+		KeyTag:      exampleKey.KeyTag(),
+		SignerName:  "example.com.",
+	}
+	if err := sig.Sign(examplePriv, []dns.RR{caa}); err != nil {
+		t.Fatalf("signing test RRSIG: %v", err)
+	}
+
+	f := &fakeExchanger{answers: map[string]*dns.Msg{
+		fakeKey("example.com.", dns.TypeDNSKEY): msgWithAnswer(exampleKey),
+		fakeKey("com.", dns.TypeDS):             msgWithAnswer(exampleDS),
+		fakeKey("com.", dns.TypeDNSKEY):         msgWithAnswer(),
+	}}
+	v := newDNSSECValidator(f)
+
+	// "com." publishes no DS at the (real, hardcoded) root trust anchors in
+	// this fake chain, so full validation can't reach dnssecSecure without
+	// forging the actual IANA root key — the part worth asserting here is
+	// that a well-formed, validly-signed RRSIG is never misreported as
+	// BOGUS due to a signature-verification failure.
+	status, err := v.validateCAA(context.Background(), "example.com.", []dns.RR{caa, sig})
+	if status == dnssecBogus && err != nil && strings.Contains(err.Error(), "validating RRSIG") {
+		t.Errorf("validateCAA incorrectly rejected a validly-signed RRSIG: %v", err)
+	}
+
+	if err := sig.Verify(exampleKey, []dns.RR{caa}); err != nil {
+		t.Errorf("RRSIG failed to verify against its own key: %v", err)
+	}
+}
+
+// TestEnclosingZoneApex checks the climb used to find the true parent zone
+// when a name's own CAA RRSIG is missing.
+func TestEnclosingZoneApex(t *testing.T) {
+	exampleKey, _, _ := newSignedZone(t, "example.com.")
+	f := &fakeExchanger{answers: map[string]*dns.Msg{
+		fakeKey("www.example.com.", dns.TypeDNSKEY): msgWithAnswer(),
+		fakeKey("example.com.", dns.TypeDNSKEY):     msgWithAnswer(exampleKey),
+	}}
+	v := newDNSSECValidator(f)
+
+	zone, err := v.enclosingZoneApex(context.Background(), "www.example.com.")
+	if err != nil {
+		t.Fatalf("enclosingZoneApex: %v", err)
+	}
+	if zone != "example.com." {
+		t.Errorf("enclosingZoneApex(www.example.com.) = %q, want %q", zone, "example.com.")
+	}
+}
@@ -0,0 +1,90 @@
+package caacheck
+
+import (
+	"fmt"
+	"strings"
+)
+
+// issueTagValue is a parsed issue/issuewild CAA record value, per the
+// grammar in RFC 8659 §4.2:
+//
+//	issuer-value = issuer-domain-name *WSP [";" *WSP parameters]
+//	parameters   = (parameter *WSP ";" *WSP parameters) / parameter
+//	parameter    = tag "=" value
+type issueTagValue struct {
+	issuerDomain string
+	params       map[string]string
+}
+
+// knownIssueTagParams are the parameter names this tool understands how to
+// enforce, per RFC 8657.
+var knownIssueTagParams = map[string]bool{
+	"accounturi":        true,
+	"validationmethods": true,
+}
+
+// parseIssueTagValue parses the value of an issue or issuewild CAA record.
+// A malformed parameter list is reported as an error rather than silently
+// ignored, since a CA must not match against a record it can't fully parse.
+func parseIssueTagValue(value string) (*issueTagValue, error) {
+	parts := strings.Split(value, ";")
+	v := &issueTagValue{
+		issuerDomain: strings.TrimSpace(parts[0]),
+		params:       make(map[string]string),
+	}
+	for _, raw := range parts[1:] {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		kv := strings.SplitN(raw, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed parameter %q", raw)
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		if key == "" {
+			return nil, fmt.Errorf("malformed parameter %q", raw)
+		}
+		v.params[key] = strings.TrimSpace(kv[1])
+	}
+	return v, nil
+}
+
+// matches reports whether the parsed issue-tag value satisfies issuer, and
+// if accountURI or validationMethods are non-empty, whether the record's
+// accounturi and validationmethods parameters (if present) are satisfied
+// too. unknownParam carries the name of an unrecognized parameter when
+// present, since such a record is always treated as non-matching.
+func (v *issueTagValue) matches(issuer, accountURI string, validationMethods []string) (ok bool, unknownParam string) {
+	for key := range v.params {
+		if !knownIssueTagParams[key] {
+			return false, key
+		}
+	}
+	if v.issuerDomain != issuer {
+		return false, ""
+	}
+	if want, ok := v.params["accounturi"]; ok {
+		if accountURI == "" || want != accountURI {
+			return false, ""
+		}
+	}
+	if want, ok := v.params["validationmethods"]; ok {
+		if len(validationMethods) == 0 {
+			return false, ""
+		}
+		allowed := strings.Split(want, ",")
+		matched := false
+		for _, m := range validationMethods {
+			for _, a := range allowed {
+				if m == strings.TrimSpace(a) {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return false, ""
+		}
+	}
+	return true, ""
+}
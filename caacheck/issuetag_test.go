@@ -0,0 +1,133 @@
+package caacheck
+
+import "testing"
+
+func TestParseIssueTagValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+		issuer  string
+		params  map[string]string
+	}{
+		{
+			name:   "bare issuer",
+			value:  "letsencrypt.org",
+			issuer: "letsencrypt.org",
+			params: map[string]string{},
+		},
+		{
+			name:   "issuer with parameters",
+			value:  "letsencrypt.org; accounturi=https://acme.example/acct/1; validationmethods=dns-01",
+			issuer: "letsencrypt.org",
+			params: map[string]string{"accounturi": "https://acme.example/acct/1", "validationmethods": "dns-01"},
+		},
+		{
+			name:    "malformed parameter with no equals sign",
+			value:   "letsencrypt.org; accounturi",
+			wantErr: true,
+		},
+		{
+			name:    "malformed parameter with empty key",
+			value:   "letsencrypt.org; =foo",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := parseIssueTagValue(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseIssueTagValue(%q) succeeded, want error", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseIssueTagValue(%q): %v", tt.value, err)
+			}
+			if v.issuerDomain != tt.issuer {
+				t.Errorf("issuerDomain = %q, want %q", v.issuerDomain, tt.issuer)
+			}
+			for k, want := range tt.params {
+				if got := v.params[k]; got != want {
+					t.Errorf("params[%q] = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIssueTagValueMatches(t *testing.T) {
+	tests := []struct {
+		name              string
+		value             string
+		issuer            string
+		accountURI        string
+		validationMethods []string
+		wantOK            bool
+		wantUnknownParam  string
+	}{
+		{
+			name:   "issuer matches, no parameters required",
+			value:  "letsencrypt.org",
+			issuer: "letsencrypt.org",
+			wantOK: true,
+		},
+		{
+			name:   "issuer mismatch",
+			value:  "sectigo.com",
+			issuer: "letsencrypt.org",
+			wantOK: false,
+		},
+		{
+			name:       "accounturi required and satisfied",
+			value:      "letsencrypt.org; accounturi=https://acme.example/acct/1",
+			issuer:     "letsencrypt.org",
+			accountURI: "https://acme.example/acct/1",
+			wantOK:     true,
+		},
+		{
+			name:       "accounturi required but request carries a different one",
+			value:      "letsencrypt.org; accounturi=https://acme.example/acct/1",
+			issuer:     "letsencrypt.org",
+			accountURI: "https://acme.example/acct/2",
+			wantOK:     false,
+		},
+		{
+			name:              "validationmethods satisfied",
+			value:             "letsencrypt.org; validationmethods=http-01,dns-01",
+			issuer:            "letsencrypt.org",
+			validationMethods: []string{"dns-01"},
+			wantOK:            true,
+		},
+		{
+			name:              "validationmethods not satisfied",
+			value:             "letsencrypt.org; validationmethods=http-01",
+			issuer:            "letsencrypt.org",
+			validationMethods: []string{"dns-01"},
+			wantOK:            false,
+		},
+		{
+			name:             "unknown parameter makes the record non-matching",
+			value:            "letsencrypt.org; futureparam=1",
+			issuer:           "letsencrypt.org",
+			wantOK:           false,
+			wantUnknownParam: "futureparam",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := parseIssueTagValue(tt.value)
+			if err != nil {
+				t.Fatalf("parseIssueTagValue(%q): %v", tt.value, err)
+			}
+			ok, unknownParam := v.matches(tt.issuer, tt.accountURI, tt.validationMethods)
+			if ok != tt.wantOK {
+				t.Errorf("matches(...) ok = %v, want %v", ok, tt.wantOK)
+			}
+			if unknownParam != tt.wantUnknownParam {
+				t.Errorf("matches(...) unknownParam = %q, want %q", unknownParam, tt.wantUnknownParam)
+			}
+		})
+	}
+}
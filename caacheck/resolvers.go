@@ -0,0 +1,126 @@
+package caacheck
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// namedExchanger pairs an Exchanger with the resolver address it talks to,
+// so fan-out results can be attributed back to their source.
+type namedExchanger struct {
+	Resolver string
+	Exchanger
+}
+
+func newExchangers(transport string, resolvers []string, timeout time.Duration) ([]namedExchanger, error) {
+	exchangers := make([]namedExchanger, 0, len(resolvers))
+	for _, r := range resolvers {
+		e, err := newExchanger(transport, r, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("setting up %q transport for resolver %q: %w", transport, r, err)
+		}
+		exchangers = append(exchangers, namedExchanger{Resolver: r, Exchanger: e})
+	}
+	return exchangers, nil
+}
+
+// resolverResult is one resolver's answer to a fanned-out query.
+type resolverResult struct {
+	Resolver      string
+	Answer        []dns.RR
+	Authenticated bool
+	Err           error
+}
+
+// fanOutQuery issues the same query to every exchanger in parallel and
+// returns every resolver's result (in exchangers order) alongside the
+// first, in list order, that succeeded.
+func fanOutQuery(ctx context.Context, name string, rrType uint16, exchangers []namedExchanger, dnssec bool) (chosen *resolverResult, all []*resolverResult) {
+	all = make([]*resolverResult, len(exchangers))
+	var wg sync.WaitGroup
+	for i, ne := range exchangers {
+		wg.Add(1)
+		go func(i int, ne namedExchanger) {
+			defer wg.Done()
+			answer, authenticated, err := query(ctx, name, rrType, ne.Exchanger, dnssec)
+			all[i] = &resolverResult{
+				Resolver:      ne.Resolver,
+				Answer:        answer,
+				Authenticated: authenticated,
+				Err:           err,
+			}
+		}(i, ne)
+	}
+	wg.Wait()
+
+	for _, r := range all {
+		if r.Err == nil {
+			chosen = r
+			break
+		}
+	}
+	return chosen, all
+}
+
+// caaKey canonicalizes a single CAA record for disagreement comparison,
+// deliberately ignoring owner name and TTL since those legitimately vary
+// across resolvers and caches.
+func caaKey(c *dns.CAA) string {
+	return fmt.Sprintf("%d %s %s", c.Flag, c.Tag, c.Value)
+}
+
+// canonicalCAASet returns a sorted, comparable representation of the CAA
+// records in answer, for use in -compare mode.
+func canonicalCAASet(answer []dns.RR) []string {
+	var keys []string
+	for _, rr := range answer {
+		if caa, ok := rr.(*dns.CAA); ok {
+			keys = append(keys, caaKey(caa))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffResolvers reports whether every successful result in results carries
+// a byte-identical (after canonicalization) CAA RRset, returning a
+// per-resolver summary for any that disagree.
+func diffResolvers(results []*resolverResult) (agree bool, diff map[string][]string) {
+	diff = make(map[string][]string)
+	var want []string
+	agree = true
+	first := true
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		got := canonicalCAASet(r.Answer)
+		diff[r.Resolver] = got
+		if first {
+			want = got
+			first = false
+			continue
+		}
+		if !equalStrings(got, want) {
+			agree = false
+		}
+	}
+	return agree, diff
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
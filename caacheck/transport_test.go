@@ -0,0 +1,235 @@
+package caacheck
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+func testCAAQuery(name string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeCAA)
+	return m
+}
+
+// TestDoHExchanger_RoundTrip exercises dohExchanger end-to-end against a
+// local HTTP server (RFC 8484 doesn't require TLS from the client's
+// perspective, so this needs no certificate machinery).
+func TestDoHExchanger_RoundTrip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			t.Errorf("unexpected request: method=%s content-type=%s", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("reading request body: %v", err)
+		}
+		q := new(dns.Msg)
+		if err := q.Unpack(body); err != nil {
+			t.Fatalf("unpacking request: %v", err)
+		}
+		resp := new(dns.Msg)
+		resp.SetReply(q)
+		resp.Answer = []dns.RR{caaAnswer(q.Question[0].Name, "issue", "letsencrypt.org")}
+		packed, err := resp.Pack()
+		if err != nil {
+			t.Fatalf("packing response: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	defer srv.Close()
+
+	e, err := newExchanger("https", srv.URL, 2*time.Second)
+	if err != nil {
+		t.Fatalf("newExchanger: %v", err)
+	}
+	resp, err := e.Exchange(context.Background(), testCAAQuery("example.com."))
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if len(resp.Answer) != 1 {
+		t.Fatalf("got %d answer records, want 1: %v", len(resp.Answer), resp.Answer)
+	}
+	caa, ok := resp.Answer[0].(*dns.CAA)
+	if !ok || caa.Value != "letsencrypt.org" {
+		t.Errorf("unexpected answer record: %v", resp.Answer[0])
+	}
+}
+
+// TestDoHExchanger_NonOKStatus checks that an HTTP error status from the
+// resolver is surfaced as an error rather than an empty answer.
+func TestDoHExchanger_NonOKStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	e, err := newExchanger("https", srv.URL, 2*time.Second)
+	if err != nil {
+		t.Fatalf("newExchanger: %v", err)
+	}
+	if _, err := e.Exchange(context.Background(), testCAAQuery("example.com.")); err == nil {
+		t.Error("Exchange succeeded against a 503 response, want an error")
+	}
+}
+
+// selfSignedCert generates an in-memory self-signed ECDSA certificate for
+// "127.0.0.1", for standing up a local DoQ listener in tests.
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating test key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("creating test certificate: %v", err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing test certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return cert, pool
+}
+
+// startFakeDoQListener stands up a local RFC 9250 DoQ server that answers
+// every query with a fixed CAA response, and returns its address.
+func startFakeDoQListener(t *testing.T, cert tls.Certificate) string {
+	t.Helper()
+	ln, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"doq"},
+	}, &quic.Config{DisablePathMTUDiscovery: true})
+	if err != nil {
+		t.Skipf("environment doesn't support binding a local QUIC listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go serveDoQConn(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveDoQConn(conn quic.Connection) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			defer stream.Close()
+			var lenBuf [2]byte
+			if _, err := io.ReadFull(stream, lenBuf[:]); err != nil {
+				return
+			}
+			qLen := binary.BigEndian.Uint16(lenBuf[:])
+			qBuf := make([]byte, qLen)
+			if _, err := io.ReadFull(stream, qBuf); err != nil {
+				return
+			}
+			q := new(dns.Msg)
+			if err := q.Unpack(qBuf); err != nil {
+				return
+			}
+			resp := new(dns.Msg)
+			resp.SetReply(q)
+			resp.Answer = []dns.RR{caaAnswer(q.Question[0].Name, "issue", "letsencrypt.org")}
+			packed, err := resp.Pack()
+			if err != nil {
+				return
+			}
+			prefixed := make([]byte, 2+len(packed))
+			binary.BigEndian.PutUint16(prefixed, uint16(len(packed)))
+			copy(prefixed[2:], packed)
+			stream.Write(prefixed)
+		}()
+	}
+}
+
+// TestDoQExchanger_RoundTrip exercises doqExchanger end-to-end against a
+// local QUIC listener.
+func TestDoQExchanger_RoundTrip(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	addr := startFakeDoQListener(t, cert)
+
+	e := &doqExchanger{addr: addr, timeout: 2 * time.Second, rootCAs: pool}
+	resp, err := e.Exchange(context.Background(), testCAAQuery("example.com."))
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if len(resp.Answer) != 1 {
+		t.Fatalf("got %d answer records, want 1: %v", len(resp.Answer), resp.Answer)
+	}
+	caa, ok := resp.Answer[0].(*dns.CAA)
+	if !ok || caa.Value != "letsencrypt.org" {
+		t.Errorf("unexpected answer record: %v", resp.Answer[0])
+	}
+}
+
+// TestDoQExchanger_TimesOutOnSilentServer is a regression test: a server
+// that accepts the connection and stream but never replies must not hang
+// the query forever.
+func TestDoQExchanger_TimesOutOnSilentServer(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	ln, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"doq"},
+	}, &quic.Config{DisablePathMTUDiscovery: true})
+	if err != nil {
+		t.Skipf("environment doesn't support binding a local QUIC listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		// Accept the stream but never write a response.
+		conn.AcceptStream(context.Background())
+	}()
+
+	e := &doqExchanger{addr: ln.Addr().String(), timeout: 200 * time.Millisecond, rootCAs: pool}
+	start := time.Now()
+	_, err = e.Exchange(context.Background(), testCAAQuery("example.com."))
+	if err == nil {
+		t.Fatal("Exchange against a silent server succeeded, want a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Exchange took %v to time out, want well under 5s", elapsed)
+	}
+}
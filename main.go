@@ -1,115 +1,29 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"math/rand"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/miekg/dns"
+	"github.com/rolandshoemaker/caatest/caacheck"
 )
 
-type records struct {
-	issue     []*dns.CAA
-	issueWild []*dns.CAA
-	iodef     []*dns.CAA
-	unknown   []*dns.CAA
-}
-
-func filter(returned []dns.RR) *records {
-	r := &records{}
-	for _, rr := range returned {
-		if rr.Header().Rrtype != dns.TypeCAA {
-			continue
-		}
-		caa, ok := rr.(*dns.CAA)
-		if !ok {
-			continue
-		}
-		switch strings.ToLower(caa.Tag) {
-		case "issue":
-			r.issue = append(r.issue, caa)
-		case "issuewild":
-			r.issueWild = append(r.issueWild, caa)
-		case "iodef":
-			r.iodef = append(r.iodef, caa)
-		default:
-			r.unknown = append(r.unknown, caa)
-		}
-	}
-	return r
-}
-
-func (r *records) containsCriticalUnknown() bool {
-	for _, rr := range r.unknown {
-		if (rr.Flag & 128) != 0 {
-			return true
-		}
-	}
-	return false
-}
-
-func (r *records) useful() bool {
-	if len(r.issue) > 0 || len(r.issueWild) > 0 {
-		return true
-	}
-	return false
-}
-
-func (r *records) print() {
-	for _, section := range [][]*dns.CAA{r.issue, r.issueWild, r.iodef, r.unknown} {
-		for _, rr := range section {
-			fmt.Printf("\t%s\n", rr.String())
-		}
-	}
-}
-
-var maxAliasRedirects = 10
-
-func query(name string, rrType uint16, resolver string, iterations int) ([]dns.RR, error) {
-	if iterations >= maxAliasRedirects {
-		return nil, fmt.Errorf("Stuck in alias loop (%d redirects)", iterations)
-	}
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(name), rrType)
-	m.RecursionDesired = true
-	resp, err := dns.Exchange(m, resolver)
-	if err != nil {
-		return nil, err
-	}
-	if resp.Rcode != dns.RcodeSuccess {
-		return nil, fmt.Errorf("Non-zero RCODE in response (%s)", dns.RcodeToString[resp.Rcode])
-	}
-
-	if len(resp.Answer) == 1 && (resp.Answer[0].Header().Rrtype == dns.TypeCNAME || resp.Answer[0].Header().Rrtype == dns.TypeDNAME) {
-		var alias string
-		switch t := resp.Answer[0].(type) {
-		case *dns.CNAME:
-			alias = t.Target
-		case *dns.DNAME:
-			alias = t.Target
-		default:
-			return nil, fmt.Errorf("Answer contains malformed %q record", dns.TypeToString[resp.Answer[0].Header().Rrtype])
-		}
-		iterations++
-		return query(alias, rrType, resolver, iterations)
-	}
-
-	return resp.Answer, nil
-}
-
-func matchesIssuer(r *dns.CAA, issuer string) bool {
-	ri := strings.TrimSpace(r.Value)
-	if index := strings.Index(ri, ";"); index > 0 {
-		ri = ri[:index]
-	}
-	return ri == issuer
-}
-
 func main() {
-	resolver := flag.String("resolver", "", "DNS server and port to send questions to (defaults to resolvers in /etc/resolv.conf if empty)")
+	resolver := flag.String("resolver", "", "Comma-separated DNS servers to send questions to (defaults to every server in /etc/resolv.conf if empty). For -transport https or quic each entry should be a resolver URL, e.g. https://1.1.1.1/dns-query or quic://dns.adguard.com")
+	transport := flag.String("transport", "udp", "Transport to use for CAA queries: udp, tcp, tls (DoT), https (DoH), or quic (DoQ)")
+	timeout := flag.Duration("timeout", 5*time.Second, "Timeout for each DNS query")
+	compare := flag.Bool("compare", false, "Require all resolvers to return byte-identical CAA RRsets, exiting non-zero and printing a diff if they disagree")
 	issuer := flag.String("issuer", "", "Name of issuer to test against (if empty exit code will always be 0 and full chain will be displayed)")
+	accountURI := flag.String("account-uri", "", "Account URI to require when an issue/issuewild record carries an accounturi parameter (RFC 8657)")
+	validationMethods := flag.String("validation-methods", "", "Comma-separated validation method names to require when an issue/issuewild record carries a validationmethods parameter (RFC 8657)")
+	dnssecMode := flag.String("dnssec", "", `DNSSEC authentication of CAA lookups: "" (disabled), "trust-ad" (trust the upstream resolver's AD bit), or "validate" (fetch DNSKEY/DS along the delegation chain and validate locally)`)
+	requireDNSSEC := flag.Bool("require-dnssec", false, "Exit non-zero if a domain's CAA set cannot be authenticated via DNSSEC")
+	output := flag.String("output", "text", `Output format: "text" or "json"`)
 	verbose := flag.Bool("verbose", false, "Print extra information about the CAA sets that are returned")
 	flag.Usage = func() {
 		fmt.Printf("Usage of caatest:\n")
@@ -125,91 +39,139 @@ func main() {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown -output format %q (must be \"text\" or \"json\")\n", *output)
+		os.Exit(1)
+	}
 
-	var upstream string
-	if *resolver == "" {
-		cc, err := dns.ClientConfigFromFile("/etc/resolv.conf")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to read nameservers from /etc/resolv.conf: %s\n", err)
-			os.Exit(1)
-		}
-		if len(cc.Servers) == 0 {
-			fmt.Fprintln(os.Stderr, "/etc/resolv.conf contains no nameservers")
+	var validationMethodList []string
+	if *validationMethods != "" {
+		validationMethodList = strings.Split(*validationMethods, ",")
+	}
+	var resolvers []string
+	if *resolver != "" {
+		resolvers = strings.Split(*resolver, ",")
+	}
+	checker := &caacheck.Checker{
+		Resolvers:         resolvers,
+		Transport:         *transport,
+		Timeout:           *timeout,
+		Compare:           *compare,
+		Issuer:            *issuer,
+		AccountURI:        *accountURI,
+		ValidationMethods: validationMethodList,
+		DNSSEC:            *dnssecMode,
+		RequireDNSSEC:     *requireDNSSEC,
+	}
+
+	result, err := checker.Check(context.Background(), domain)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to check %q: %s\n", domain, err)
+		os.Exit(1)
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode result: %s\n", err)
 			os.Exit(1)
 		}
-		upstream = fmt.Sprintf("%s:%s", cc.Servers[rand.Intn(len(cc.Servers))], cc.Port)
 	} else {
-		upstream = *resolver
+		printText(result, *issuer, *verbose)
 	}
 
-	labels := strings.Split(strings.TrimRight(domain, "."), ".")
-	if labels[len(labels)-1] == "" {
-		labels = labels[:len(labels)-2]
+	os.Exit(exitCode(result))
+}
+
+// exitCode maps a Result to the CLI's historical exit codes: 0 for an
+// authorized match (or an unconstrained full-chain display), 1 for an
+// ordinary failure, and 3 for a DNSSEC BOGUS result.
+func exitCode(r *caacheck.Result) int {
+	switch r.ErrorKind {
+	case caacheck.ErrKindNone:
+		return 0
+	case caacheck.ErrKindDNSSECBogus:
+		return 3
+	default:
+		return 1
 	}
-	for i := 0; i < len(labels); i++ {
-		dn := strings.Join(labels[i:], ".")
-		resp, err := query(dn, dns.TypeCAA, upstream, 0)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "[%s] Failed to send CAA query to %q: %s\n", dn, upstream, err)
-			os.Exit(1)
+}
+
+func printText(r *caacheck.Result, issuer string, verbose bool) {
+	for _, lr := range r.Labels {
+		if lr.Alias != "" {
+			if issuer == "" || verbose {
+				fmt.Printf("[%s] CNAME/DNAME to %s\n", lr.Name, lr.Alias)
+			}
+			continue
 		}
-		if len(resp) == 0 {
-			if *issuer == "" || *verbose {
-				fmt.Printf("[%s] Empty response\n", dn)
+		if lr.Empty {
+			if issuer == "" || verbose {
+				fmt.Printf("[%s] Empty response\n", lr.Name)
 			}
 			continue
 		}
-		set := filter(resp)
-		if set.containsCriticalUnknown() {
-			fmt.Fprintf(os.Stderr, "[%s] CAA set contains a unknown record with critical bit set\n", dn)
-			if *verbose {
-				set.print()
+		if lr.DNSSECStatus != "" && (verbose || lr.DNSSECStatus != "secure") {
+			fmt.Printf("[%s] DNSSEC status: %s\n", lr.Name, lr.DNSSECStatus)
+		}
+		if verbose {
+			for _, skipped := range lr.Skipped {
+				fmt.Printf("[%s] Skipped record (%s): %s\n", lr.Name, skipped.Reason, skipped.Record.String())
 			}
-			os.Exit(1)
 		}
-		if !set.useful() {
-			if *issuer == "" || *verbose {
-				fmt.Printf("[%s] CAA set contains no relevant records\n", dn)
-				if *verbose {
-					set.print()
+		if lr.Records.ContainsCriticalUnknown() {
+			fmt.Fprintf(os.Stderr, "[%s] CAA set contains a unknown record with critical bit set\n", lr.Name)
+			if verbose {
+				printRecords(lr.Records)
+			}
+			continue
+		}
+		if !lr.Records.Useful() {
+			if issuer == "" || verbose {
+				fmt.Printf("[%s] CAA set contains no relevant records\n", lr.Name)
+				if verbose {
+					printRecords(lr.Records)
 				}
 			}
 			continue
 		}
-		if *issuer == "" {
-			fmt.Printf("[%s] CAA set contains following records\n", dn)
-			set.print()
+		if issuer == "" {
+			fmt.Printf("[%s] CAA set contains following records\n", lr.Name)
+			printRecords(lr.Records)
 			continue
 		}
-		if strings.HasPrefix(domain, ".*") {
-			if len(set.issueWild) == 0 {
-				continue // I think this is wrong?
-			}
-			for _, rr := range set.issueWild {
-				fmt.Println(rr)
-			}
-		} else {
-			if len(set.issue) == 0 {
-				if *verbose {
-					fmt.Printf("[%s] No issue tag records in set\n", dn)
-					set.print()
-				}
-				continue
-			}
-			for _, rr := range set.issue {
-				if matchesIssuer(rr, *issuer) {
-					fmt.Printf("[%s] Valid issue tag record for found %q in set\n", dn, *issuer)
-					if *verbose {
-						set.print()
-					}
-					os.Exit(0)
-				}
+		if r.Authorized && lr.Name == r.MatchedLabel {
+			fmt.Printf("[%s] Valid issue tag record for found %q in set\n", lr.Name, issuer)
+			if verbose {
+				printRecords(lr.Records)
 			}
-			fmt.Fprintf(os.Stderr, "[%s] Issuer %q not present in CAA issue tag set\n", dn, *issuer)
-			if *verbose {
-				set.print()
-			}
-			os.Exit(1)
+		}
+	}
+
+	switch r.ErrorKind {
+	case caacheck.ErrKindNoMatch:
+		fmt.Fprintf(os.Stderr, "Issuer %q not present in CAA issue tag set\n", issuer)
+	case caacheck.ErrKindAliasLoop:
+		fmt.Fprintln(os.Stderr, "Stuck in an alias (CNAME/DNAME) loop while climbing the tree")
+	case caacheck.ErrKindServfail:
+		fmt.Fprintln(os.Stderr, "Failed to get a usable answer from the resolver")
+	case caacheck.ErrKindDNSSECBogus:
+		fmt.Fprintln(os.Stderr, "DNSSEC validation of CAA set is BOGUS")
+	case caacheck.ErrKindDNSSECInsecure:
+		fmt.Fprintln(os.Stderr, "CAA set could not be authenticated via DNSSEC")
+	case caacheck.ErrKindDisagreement:
+		fmt.Fprintln(os.Stderr, "Resolvers disagree on the CAA RRset:")
+		for resolver, set := range r.Disagreement {
+			fmt.Fprintf(os.Stderr, "\t%s: %v\n", resolver, set)
+		}
+	}
+}
+
+func printRecords(r *caacheck.Records) {
+	for _, section := range [][]*dns.CAA{r.Issue, r.IssueWild, r.Iodef, r.Unknown} {
+		for _, rr := range section {
+			fmt.Printf("\t%s\n", rr.String())
 		}
 	}
 }